@@ -0,0 +1,110 @@
+// Copyright (c) 2024 Josh Rickmar <jrick@zettaport.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rotator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Namer controls how rotated log files are named and how existing rotated
+// files are recognized and ordered.  RotatedName and Parse are always given
+// (and must agree on) the rotated file's name without any compression
+// suffix; the Rotator appends and strips "."+zSuffix itself, since a file
+// may exist briefly without it while compression is still in progress.
+type Namer interface {
+	// RotatedName returns the name to give the base log file's seq'th
+	// rotation, performed at time t.
+	RotatedName(base string, seq int, t time.Time) string
+
+	// Parse extracts the sequence number and rotation time encoded in a
+	// name previously produced by RotatedName.  ok is false if name does
+	// not match this Namer's scheme.
+	Parse(name string) (seq int, t time.Time, ok bool)
+}
+
+// DefaultNamer is the Namer used by a Rotator unless SetNamer is called. It
+// names rotations "base.N", where N is the sequence number the Rotator
+// assigns (one more than the highest found among existing rotations), and
+// does not encode a timestamp.
+type DefaultNamer struct{}
+
+// RotatedName implements Namer.
+func (DefaultNamer) RotatedName(base string, seq int, t time.Time) string {
+	return fmt.Sprintf("%s.%d", base, seq)
+}
+
+// Parse implements Namer.
+func (DefaultNamer) Parse(name string) (seq int, t time.Time, ok bool) {
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return 0, time.Time{}, false
+	}
+	seq, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	return seq, time.Time{}, true
+}
+
+// timestampLayout is the fixed-width portion of a TimestampNamer name that
+// encodes the rotation time.
+const timestampLayout = "2006-01-02T15-04-05.000"
+
+// TimestampNamer names rotations "base-<timestamp>-N", embedding the local
+// time of rotation in a sortable, filesystem- and log-shipping-tool-friendly
+// format, in addition to the sequence number N.
+type TimestampNamer struct{}
+
+// RotatedName implements Namer.
+func (TimestampNamer) RotatedName(base string, seq int, t time.Time) string {
+	return fmt.Sprintf("%s-%s-%d", base, t.Format(timestampLayout), seq)
+}
+
+// Parse implements Namer.
+func (TimestampNamer) Parse(name string) (seq int, t time.Time, ok bool) {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return 0, time.Time{}, false
+	}
+	seq, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+
+	rest := name[:i]
+	if len(rest) < len(timestampLayout) {
+		return 0, time.Time{}, false
+	}
+	ts := rest[len(rest)-len(timestampLayout):]
+	t, err = time.ParseInLocation(timestampLayout, ts, time.Local)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+
+	return seq, t, true
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2024 Josh Rickmar <jrick@zettaport.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rotator
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDefaultNamerRoundTrip guards DefaultNamer's "base.N" scheme: Parse
+// must recover the seq RotatedName encoded, and reject names it didn't
+// produce.
+func TestDefaultNamerRoundTrip(t *testing.T) {
+	var n DefaultNamer
+
+	name := n.RotatedName("/var/log/app.log", 3, time.Now())
+	if want := "/var/log/app.log.3"; name != want {
+		t.Fatalf("RotatedName = %q, want %q", name, want)
+	}
+
+	seq, _, ok := n.Parse(name)
+	if !ok {
+		t.Fatalf("Parse(%q) = false, want true", name)
+	}
+	if seq != 3 {
+		t.Fatalf("Parse(%q) seq = %d, want 3", name, seq)
+	}
+
+	if _, _, ok := n.Parse("/var/log/app.log"); ok {
+		t.Fatalf("Parse of a name with no sequence suffix should fail")
+	}
+	if _, _, ok := n.Parse("/var/log/app.log.notanumber"); ok {
+		t.Fatalf("Parse of a non-numeric suffix should fail")
+	}
+}
+
+// TestTimestampNamerRoundTrip guards TimestampNamer's
+// "base-<timestamp>-N" scheme: Parse must recover both the seq and the
+// rotation time RotatedName encoded.
+func TestTimestampNamerRoundTrip(t *testing.T) {
+	var n TimestampNamer
+
+	rotTime := time.Date(2024, time.March, 5, 13, 4, 5, 250_000_000, time.Local)
+	name := n.RotatedName("/var/log/app.log", 2, rotTime)
+
+	seq, parsedTime, ok := n.Parse(name)
+	if !ok {
+		t.Fatalf("Parse(%q) = false, want true", name)
+	}
+	if seq != 2 {
+		t.Fatalf("Parse(%q) seq = %d, want 2", name, seq)
+	}
+	if !parsedTime.Equal(rotTime) {
+		t.Fatalf("Parse(%q) time = %v, want %v", name, parsedTime, rotTime)
+	}
+
+	if _, _, ok := n.Parse("/var/log/app.log"); ok {
+		t.Fatalf("Parse of a name with no timestamp/sequence suffix should fail")
+	}
+}
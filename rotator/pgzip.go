@@ -0,0 +1,190 @@
+// Copyright (c) 2024 Josh Rickmar <jrick@zettaport.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rotator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// parallelGzip is a Compressor that splits its input into fixed-size blocks
+// and compresses them concurrently across a worker pool.  Each block is
+// written out as its own gzip member; concatenated gzip members form a
+// single valid gzip stream per RFC 1952, so the output reads back with any
+// standard gzip reader.
+type parallelGzip struct {
+	level     int
+	blockSize int
+	sem       chan struct{}
+
+	w   io.Writer
+	buf []byte
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	pending  map[uint64][]byte
+	nextSeq  uint64
+	writeSeq uint64
+	err      error
+}
+
+// NewParallelGzipCompressor returns a Compressor that compresses the stream
+// passed to it using up to blocks goroutines, each handling one blockSize
+// chunk of input at a time.  level is a compress/gzip compression level.  If
+// blockSize is not positive it defaults to 1 MiB, and if blocks is not
+// positive it defaults to runtime.GOMAXPROCS(0).
+//
+// Splitting the stream this way trades a small amount of compression ratio
+// (each block starts a fresh gzip member, so cross-block back-references are
+// lost) for substantially lower wall-clock time compressing large rotated
+// files on multi-core hosts.
+func NewParallelGzipCompressor(level, blockSize, blocks int) Compressor {
+	if blockSize <= 0 {
+		blockSize = 1 << 20
+	}
+	if blocks <= 0 {
+		blocks = runtime.GOMAXPROCS(0)
+	}
+	return &parallelGzip{
+		level:     level,
+		blockSize: blockSize,
+		sem:       make(chan struct{}, blocks),
+	}
+}
+
+// Reset discards any buffered state and begins writing a new gzip stream to
+// w.
+func (pg *parallelGzip) Reset(w io.Writer) {
+	pg.w = w
+	pg.buf = pg.buf[:0]
+	pg.pending = make(map[uint64][]byte)
+	pg.nextSeq = 0
+	pg.writeSeq = 0
+	pg.err = nil
+}
+
+// Write buffers p, dispatching a block to the worker pool each time the
+// buffer fills past blockSize.
+func (pg *parallelGzip) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		space := pg.blockSize - len(pg.buf)
+		if space > len(p) {
+			space = len(p)
+		}
+		pg.buf = append(pg.buf, p[:space]...)
+		p = p[space:]
+		n += space
+
+		if len(pg.buf) >= pg.blockSize {
+			pg.compressBlock(pg.buf)
+			pg.buf = make([]byte, 0, pg.blockSize)
+		}
+	}
+
+	pg.mu.Lock()
+	err = pg.err
+	pg.mu.Unlock()
+	return n, err
+}
+
+// compressBlock hands block off to a worker goroutine, blocking until a
+// worker slot is free.
+func (pg *parallelGzip) compressBlock(block []byte) {
+	seq := pg.nextSeq
+	pg.nextSeq++
+
+	pg.sem <- struct{}{}
+	pg.wg.Add(1)
+	go func() {
+		defer pg.wg.Done()
+		defer func() { <-pg.sem }()
+
+		var out bytes.Buffer
+		zw, err := gzip.NewWriterLevel(&out, pg.level)
+		if err == nil {
+			_, err = zw.Write(block)
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+
+		pg.mu.Lock()
+		if err != nil && pg.err == nil {
+			pg.err = err
+		}
+		pg.pending[seq] = out.Bytes()
+		pg.mu.Unlock()
+		pg.drain()
+	}()
+}
+
+// drain writes out any compressed blocks that have arrived in order,
+// serializing access to the underlying writer.
+func (pg *parallelGzip) drain() {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+
+	for {
+		data, ok := pg.pending[pg.writeSeq]
+		if !ok {
+			return
+		}
+		delete(pg.pending, pg.writeSeq)
+		pg.writeSeq++
+
+		if pg.err == nil {
+			if _, err := pg.w.Write(data); err != nil {
+				pg.err = err
+			}
+		}
+	}
+}
+
+// Flush compresses and writes out any buffered input, waiting for all
+// outstanding workers to finish.  If nothing was ever written, a single
+// empty block is still compressed so the output is a valid (if empty)
+// gzip stream, matching what compress/gzip's own Writer produces for a
+// Close with no writes, rather than zero bytes.
+func (pg *parallelGzip) Flush() error {
+	if len(pg.buf) > 0 || pg.nextSeq == 0 {
+		pg.compressBlock(pg.buf)
+		pg.buf = pg.buf[:0]
+	}
+	pg.wg.Wait()
+	pg.drain()
+
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	return pg.err
+}
+
+// Close flushes any remaining input.  It does not close the underlying
+// writer.
+func (pg *parallelGzip) Close() error {
+	return pg.Flush()
+}
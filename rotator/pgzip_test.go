@@ -0,0 +1,107 @@
+// Copyright (c) 2024 Josh Rickmar <jrick@zettaport.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rotator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+// TestParallelGzipMultiBlockRoundTrip guards the compressor's core path:
+// input spanning several blocks must be split, compressed concurrently, and
+// reassembled by drain in the original order, producing a concatenated gzip
+// stream that decompresses back to exactly the input.
+func TestParallelGzipMultiBlockRoundTrip(t *testing.T) {
+	const blockSize = 4096
+	const blocks = 5
+
+	input := make([]byte, blockSize*blocks+blockSize/2)
+	for i := range input {
+		input[i] = byte(i % 251)
+	}
+
+	c := NewParallelGzipCompressor(gzip.DefaultCompression, blockSize, 4)
+
+	var out bytes.Buffer
+	c.Reset(&out)
+
+	// Write in irregularly sized chunks that don't line up with blockSize,
+	// exercising the buffering in Write as well as the block boundary in
+	// compressBlock.
+	for off := 0; off < len(input); {
+		n := 777
+		if off+n > len(input) {
+			n = len(input) - off
+		}
+		if _, err := c.Write(input[off : off+n]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		off += n
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("gzip.NewReader on multi-block output: %v", err)
+	}
+	zr.Multistream(true)
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading decompressed output: %v", err)
+	}
+	if !bytes.Equal(got, input) {
+		t.Fatalf("round trip mismatch: got %d bytes, want %d bytes", len(got), len(input))
+	}
+}
+
+// TestParallelGzipEmptyInput guards against Flush/Close producing zero bytes
+// of output when nothing was ever written: the result must still be a valid
+// gzip stream, matching what compress/gzip's own Writer produces when
+// Close is called without any prior Write.
+func TestParallelGzipEmptyInput(t *testing.T) {
+	c := NewParallelGzipCompressor(gzip.DefaultCompression, 1<<20, 2)
+
+	var out bytes.Buffer
+	c.Reset(&out)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatalf("gzip.NewReader on empty-input output: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("reading decompressed output: %v", err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("expected empty decompressed output, got %d bytes", len(data))
+	}
+}
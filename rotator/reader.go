@@ -0,0 +1,267 @@
+// Copyright (c) 2024 Josh Rickmar <jrick@zettaport.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rotator
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// tailPollInterval is how often Tail rechecks for a new rotation or
+// additional data once it has caught up to the live log file.
+const tailPollInterval = 500 * time.Millisecond
+
+// segment identifies one file making up a (possibly rotated) log, in the
+// order it should be read.
+type segment struct {
+	path string
+	gz   bool
+}
+
+// segments returns the rotated and live files belonging to filename, ordered
+// oldest to newest using namer to recognize and order rotated files.  The
+// oldest rotation sorts first and the live file, if present, always comes
+// last.
+func segments(filename, zSuffix string, namer Namer) ([]segment, error) {
+	dir := filepath.Dir(filename)
+	base := filepath.Base(filename)
+	existing, err := filepath.Glob(filepath.Join(dir, base+".*"))
+	if err != nil {
+		return nil, err
+	}
+
+	// groupRotations dedupes on seq, preferring the compressed sibling: a
+	// rotated file is briefly visible both as "name.N" and as the complete
+	// "name.N.gz" while rotate's background compression is renaming one
+	// into the other, and a crash can make that window permanent. Reading
+	// both would duplicate that rotation's contents.
+	groups := groupRotations(existing, zSuffix, namer)
+	sort.Slice(groups, func(i, j int) bool { return groups[i].seq < groups[j].seq })
+
+	segs := make([]segment, 0, len(groups)+1)
+	for _, g := range groups {
+		segs = append(segs, segment{g.path, g.gz})
+	}
+	if _, err := os.Stat(filename); err == nil {
+		segs = append(segs, segment{filename, false})
+	}
+	return segs, nil
+}
+
+// segReader reads a single segment, transparently gunzipping it if
+// necessary.
+type segReader struct {
+	f  *os.File
+	zr *gzip.Reader
+}
+
+func openSegment(seg segment) (*segReader, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return nil, err
+	}
+	if !seg.gz {
+		return &segReader{f: f}, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &segReader{f: f, zr: zr}, nil
+}
+
+func (s *segReader) Read(p []byte) (int, error) {
+	if s.zr != nil {
+		return s.zr.Read(p)
+	}
+	return s.f.Read(p)
+}
+
+func (s *segReader) Close() error {
+	var err error
+	if s.zr != nil {
+		err = s.zr.Close()
+	}
+	if e := s.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// multiReader concatenates a fixed list of segments into a single stream,
+// advancing to the next segment on EOF.
+type multiReader struct {
+	segs []segment
+	idx  int
+	cur  *segReader
+}
+
+func (m *multiReader) Read(p []byte) (int, error) {
+	for {
+		if m.cur == nil {
+			if m.idx >= len(m.segs) {
+				return 0, io.EOF
+			}
+			cur, err := openSegment(m.segs[m.idx])
+			if err != nil {
+				return 0, err
+			}
+			m.cur = cur
+		}
+
+		n, err := m.cur.Read(p)
+		if err == io.EOF {
+			m.cur.Close()
+			m.cur = nil
+			m.idx++
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (m *multiReader) Close() error {
+	if m.cur != nil {
+		return m.cur.Close()
+	}
+	return nil
+}
+
+// Open returns a reader over the Rotator's complete log history: every
+// rotated file, oldest first, transparently gunzipped as needed, followed by
+// the live file.  The returned reader does not follow further rotations; use
+// Tail for that.
+func (r *Rotator) Open() (io.ReadCloser, error) {
+	segs, err := segments(r.filename, r.zSuffix, r.namer)
+	if err != nil {
+		return nil, err
+	}
+	return &multiReader{segs: segs}, nil
+}
+
+// OpenLog is the standalone equivalent of (*Rotator).Open, for use by
+// programs that only know a rotator's filename (e.g. a separate process
+// reading logs written by another that owns the Rotator).  Rotated files are
+// assumed to use the default "gz" suffix and DefaultNamer; pass a Rotator
+// configured with SetCompressor/SetNamer and call its Open method instead if
+// either was customized.
+func OpenLog(filename string) (io.ReadCloser, error) {
+	segs, err := segments(filename, "gz", DefaultNamer{})
+	if err != nil {
+		return nil, err
+	}
+	return &multiReader{segs: segs}, nil
+}
+
+// tailReader is like multiReader, but rechecks the filesystem for newly
+// rotated or appended data once it reaches the end of what it already knows
+// about, instead of returning io.EOF.
+type tailReader struct {
+	ctx      context.Context
+	filename string
+	zSuffix  string
+	namer    Namer
+	segs     []segment
+	idx      int
+	cur      *segReader
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	for {
+		if t.cur == nil {
+			cur, err := openSegment(t.segs[t.idx])
+			if err != nil {
+				return 0, err
+			}
+			t.cur = cur
+		}
+
+		n, err := t.cur.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+
+		// Reached the end of the current segment.  If a newer segment has
+		// appeared (the log rotated), move on to it; otherwise this is the
+		// live file and we wait for more data or another rotation.
+		segs, serr := segments(t.filename, t.zSuffix, t.namer)
+		if serr != nil {
+			return 0, serr
+		}
+		if len(segs) > t.idx+1 {
+			t.cur.Close()
+			t.cur = nil
+			t.idx++
+			t.segs = segs
+			continue
+		}
+
+		select {
+		case <-t.ctx.Done():
+			return 0, t.ctx.Err()
+		case <-time.After(tailPollInterval):
+		}
+	}
+}
+
+func (t *tailReader) Close() error {
+	if t.cur != nil {
+		return t.cur.Close()
+	}
+	return nil
+}
+
+// Tail returns a reader over the Rotator's log history like Open, but when
+// follow is true, the reader keeps reading past the end of the live file
+// instead of returning io.EOF: it polls for newly appended data and
+// transparently reopens the next file once a rotation renames the one it is
+// reading. The returned reader stops waiting for new data once ctx is
+// cancelled or times out.  When follow is false, Tail behaves exactly like
+// Open and ctx is not consulted.
+func (r *Rotator) Tail(ctx context.Context, follow bool) (io.ReadCloser, error) {
+	segs, err := segments(r.filename, r.zSuffix, r.namer)
+	if err != nil {
+		return nil, err
+	}
+	if !follow {
+		return &multiReader{segs: segs}, nil
+	}
+	if len(segs) == 0 {
+		segs = []segment{{path: r.filename}}
+	}
+	return &tailReader{ctx: ctx, filename: r.filename, zSuffix: r.zSuffix, namer: r.namer, segs: segs}, nil
+}
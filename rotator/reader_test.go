@@ -0,0 +1,239 @@
+// Copyright (c) 2024 Josh Rickmar <jrick@zettaport.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rotator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeGzip(t *testing.T, path string, content []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOpenSkipsStaleUncompressedDuplicate guards against Open/OpenLog
+// reading a rotation's contents twice when both the uncompressed "name.N"
+// and the already-complete "name.N.gz" are present on disk at once, as
+// happens transiently while rotate's background compression is renaming one
+// into the other, or permanently if a crash lands in that window before the
+// uncompressed original is removed.
+func TestOpenSkipsStaleUncompressedDuplicate(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.log")
+
+	if err := os.WriteFile(filename, []byte("live\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rotated := filename + ".1"
+	if err := os.WriteFile(rotated, []byte("rotated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeGzip(t, rotated+".gz", []byte("rotated\n"))
+
+	rc, err := OpenLog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "rotated\nlive\n"; string(data) != want {
+		t.Fatalf("OpenLog: got %q, want %q (rotation duplicated)", data, want)
+	}
+}
+
+// TestRecoverTmpRemovesStaleUncompressed guards against New leaving a stray
+// uncompressed rotation on disk forever when a crash occurred after
+// compress renamed its ".tmp" output to the final compressed name but
+// before it removed the uncompressed original.
+func TestRecoverTmpRemovesStaleUncompressed(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.log")
+
+	rotated := filename + ".1"
+	if err := os.WriteFile(rotated, []byte("rotated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeGzip(t, rotated+".gz", []byte("rotated\n"))
+
+	r, err := New(filename, 1000, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := os.Stat(rotated); !os.IsNotExist(err) {
+		t.Fatalf("expected stale uncompressed rotation to be removed by New, stat err = %v", err)
+	}
+	if _, err := os.Stat(rotated + ".gz"); err != nil {
+		t.Fatalf("compressed rotation should still exist: %v", err)
+	}
+}
+
+// TestPruneOldRemovesStaleUncompressedDuplicate guards against listRotated's
+// dedup-by-seq making pruneOld blind to a stale uncompressed duplicate: once
+// its compressed sibling ages past retentionAge, both copies of that
+// rotation must be removed together, not just the compressed one, or the
+// uncompressed duplicate leaks on disk forever.
+func TestPruneOldRemovesStaleUncompressedDuplicate(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.log")
+
+	r, err := New(filename, 1000, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.SetMaxAge(time.Nanosecond)
+
+	rotated := filename + ".1"
+	if err := os.WriteFile(rotated, []byte("rotated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeGzip(t, rotated+".gz", []byte("rotated\n"))
+
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(rotated, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(rotated+".gz", past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	r.pruneOld()
+
+	if _, err := os.Stat(rotated); !os.IsNotExist(err) {
+		t.Fatalf("expected stale uncompressed duplicate to be pruned, stat err = %v", err)
+	}
+	if _, err := os.Stat(rotated + ".gz"); !os.IsNotExist(err) {
+		t.Fatalf("expected compressed rotation to be pruned, stat err = %v", err)
+	}
+}
+
+// TestOpenReadsAcrossRotations is a basic regression test for Open: it must
+// read every rotated file oldest-first, transparently gunzipping compressed
+// ones, followed by the live file.
+func TestOpenReadsAcrossRotations(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.log")
+
+	if err := os.WriteFile(filename, []byte("three\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename+".1", []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeGzip(t, filename+".2.gz", []byte("two\n"))
+
+	r, err := New(filename, 1000, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	rc, err := r.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "one\ntwo\nthree\n"; string(data) != want {
+		t.Fatalf("Open: got %q, want %q", data, want)
+	}
+}
+
+// TestTailFollowsRotation is a basic regression test for Tail with follow
+// set: it must keep reading past what looked like the end of the live file
+// once a rotation renames it, picking up the newly live file without the
+// caller needing to reopen anything.
+func TestTailFollowsRotation(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.log")
+
+	r, err := New(filename, 1<<20, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if _, err := r.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	rc, err := r.Tail(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	buf := make([]byte, len("before\n"))
+	if _, err := io.ReadFull(rc, buf); err != nil {
+		t.Fatalf("reading pre-rotation data: %v", err)
+	}
+	if string(buf) != "before\n" {
+		t.Fatalf("got %q, want %q", buf, "before\n")
+	}
+
+	if err := os.Rename(filename, filename+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, []byte("after\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buf = make([]byte, len("after\n"))
+	if _, err := io.ReadFull(rc, buf); err != nil {
+		t.Fatalf("reading post-rotation data: %v", err)
+	}
+	if string(buf) != "after\n" {
+		t.Fatalf("got %q, want %q", buf, "after\n")
+	}
+}
@@ -24,9 +24,10 @@
 // OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
 // Package rotator implements a simple logfile rotator. Logs are read from an
-// io.Reader and are written to a file until they reach a specified size. The
-// log is then truncated and (by default) gzipped to another file or
-// compressed with a user-configurable compression scheme.
+// io.Reader and are written to a file until they reach a specified size, or
+// optionally until a configured rotation schedule elapses. The log is then
+// truncated and (by default) gzipped to another file or compressed with a
+// user-configurable compression scheme.
 package rotator
 
 import (
@@ -36,9 +37,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // nl is a byte slice containing a newline byte.  It is used to avoid creating
@@ -58,23 +59,48 @@ type Rotator struct {
 	zSuffix   string
 	zMu       sync.Mutex
 	wg        sync.WaitGroup
+
+	mu           sync.Mutex
+	firstWrite   time.Time
+	maxAge       time.Duration
+	daily        bool
+	timerStop    chan struct{}
+	timerDone    chan struct{}
+	timerWake    chan struct{}
+	retentionAge time.Duration
+	lock         io.Closer
+	namer        Namer
 }
 
 // New returns a new Rotator.  The rotator can be used either by reading input
 // from an io.Reader by calling Run, or writing directly to the Rotator with
 // Write.
+//
+// New takes an exclusive lock on filename+".lock" for the lifetime of the
+// Rotator (released by Close) so that two processes accidentally pointed at
+// the same log path cannot race the numeric-suffix allocation in rotate.  It
+// also removes or resumes any ".tmp" files left behind by a compression that
+// was interrupted by a crash.
 func New(filename string, thresholdKB int64, tee bool, maxRolls int) (*Rotator, error) {
+	lock, err := lockFile(filename + ".lock")
+	if err != nil {
+		return nil, err
+	}
+
 	f, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
 	if err != nil {
+		lock.Close()
 		return nil, err
 	}
 
 	stat, err := f.Stat()
 	if err != nil {
+		f.Close()
+		lock.Close()
 		return nil, err
 	}
 
-	return &Rotator{
+	r := &Rotator{
 		size:      stat.Size(),
 		threshold: 1000 * thresholdKB,
 		maxRolls:  maxRolls,
@@ -83,7 +109,77 @@ func New(filename string, thresholdKB int64, tee bool, maxRolls int) (*Rotator,
 		tee:       tee,
 		zw:        gzip.NewWriter(nil),
 		zSuffix:   "gz",
-	}, nil
+		lock:      lock,
+		namer:     DefaultNamer{},
+	}
+	r.recoverTmp()
+	return r, nil
+}
+
+// recoverTmp cleans up after a crash that occurred mid-compression.  Any
+// "*.tmp" file left over from a previous compress is removed, and if the
+// uncompressed rotated file it was being built from is still present, it is
+// recompressed synchronously before New returns.
+func (r *Rotator) recoverTmp() {
+	dir := filepath.Dir(r.filename)
+	base := filepath.Base(r.filename)
+	tmps, err := filepath.Glob(filepath.Join(dir, base+".*.tmp"))
+	if err != nil {
+		return
+	}
+
+	for _, tmp := range tmps {
+		os.Remove(tmp)
+
+		src := strings.TrimSuffix(tmp, ".tmp")
+		if r.zSuffix != "" {
+			src = strings.TrimSuffix(src, "."+r.zSuffix)
+		}
+		if src == tmp || r.zw == nil {
+			continue
+		}
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+
+		r.zMu.Lock()
+		if err := r.compress(src); err == nil {
+			os.Remove(src)
+		}
+		r.zMu.Unlock()
+	}
+
+	r.removeStaleUncompressed()
+}
+
+// removeStaleUncompressed deletes an uncompressed rotated file left behind
+// by a crash that occurred after compress renamed its ".tmp" output to the
+// final ".<zSuffix>" name but before it removed the uncompressed original:
+// without this, the two files sit side by side forever and Open/Tail read
+// that rotation's contents twice on every call, not just during the brief
+// window compress normally closes on its own.
+func (r *Rotator) removeStaleUncompressed() {
+	if r.zSuffix == "" {
+		return
+	}
+
+	dir := filepath.Dir(r.filename)
+	base := filepath.Base(r.filename)
+	existing, err := filepath.Glob(filepath.Join(dir, base+".*"))
+	if err != nil {
+		return
+	}
+
+	// Route through groupRotations/r.namer.Parse, the same as every other
+	// deletion candidate in this file, rather than matching raw names
+	// against the zSuffix: an unrelated sibling pair that merely happens to
+	// share the base+".*" glob and a ".gz"-suffixed name (but isn't a
+	// rotation the Namer recognizes) must never be touched.
+	for _, g := range groupRotations(existing, r.zSuffix, r.namer) {
+		if g.stale != "" {
+			os.Remove(g.stale)
+		}
+	}
 }
 
 // Compressor writes a compressed stream to an underlying writer.  The
@@ -106,6 +202,154 @@ func (r *Rotator) SetCompressor(zw Compressor, suffix string) {
 	r.zSuffix = strings.TrimPrefix(suffix, ".")
 }
 
+// SetRotationSchedule causes the log to be rotated once interval has elapsed
+// since the first write to the current file, independent of the size
+// threshold.  This is useful for services that log infrequently but still
+// expect their logs to roll on a regular cadence.
+//
+// SetRotationSchedule is not concurrent safe and must be called before the
+// Rotator is run.  It starts a background goroutine that is stopped by
+// Close.
+func (r *Rotator) SetRotationSchedule(interval time.Duration) {
+	r.mu.Lock()
+	r.maxAge = interval
+	r.mu.Unlock()
+	r.startTimer()
+}
+
+// SetDailyRotation causes the log to be rotated at the start of each local
+// day, in addition to any size- or age-based rotation already configured.
+//
+// SetDailyRotation is not concurrent safe and must be called before the
+// Rotator is run.  It starts a background goroutine that is stopped by
+// Close.
+func (r *Rotator) SetDailyRotation() {
+	r.mu.Lock()
+	r.daily = true
+	r.mu.Unlock()
+	r.startTimer()
+}
+
+// SetMaxAge configures age-based retention of rotated log files: whenever the
+// log is rotated, any rotated (and possibly compressed) sibling file whose
+// modification time is older than age is removed, regardless of maxRolls.
+// A zero age, the default, disables age-based retention and leaves pruning
+// to maxRolls alone.
+//
+// SetMaxAge is not concurrent safe and must be called before the Rotator is
+// run.
+func (r *Rotator) SetMaxAge(age time.Duration) {
+	r.retentionAge = age
+}
+
+// SetNamer changes how rotated log files are named and recognized.  By
+// default, a Rotator uses DefaultNamer.
+//
+// SetNamer is not concurrent safe and must be called before the Rotator is
+// run.
+func (r *Rotator) SetNamer(namer Namer) {
+	r.namer = namer
+}
+
+// startTimer launches the background goroutine that rotates the log on a
+// schedule.  It is a no-op if a timer goroutine is already running.
+func (r *Rotator) startTimer() {
+	if r.timerStop != nil {
+		return
+	}
+	r.timerStop = make(chan struct{})
+	r.timerDone = make(chan struct{})
+	r.timerWake = make(chan struct{}, 1)
+	go r.scheduleLoop(r.timerStop, r.timerDone, r.timerWake)
+}
+
+// wakeTimer nudges a running scheduleLoop to recompute its sleep duration
+// immediately instead of waiting out whatever it last slept for.  This
+// matters because firstWrite can transition from zero to set while the loop
+// is already asleep on the stale (24h default) deadline computed before the
+// first write landed; without a nudge, the first scheduled rotation after
+// each process start would be delayed until that stale deadline instead of
+// firstWrite+maxAge. It is a no-op if no schedule is configured.
+func (r *Rotator) wakeTimer() {
+	if r.timerWake == nil {
+		return
+	}
+	select {
+	case r.timerWake <- struct{}{}:
+	default:
+	}
+}
+
+// scheduleLoop wakes up at each scheduled rotation time and rotates the log
+// if it is non-empty, until stop is closed.  wake lets other goroutines
+// interrupt an in-progress sleep to force an immediate recompute.
+func (r *Rotator) scheduleLoop(stop, done, wake chan struct{}) {
+	defer close(done)
+
+	// start is when the current wait began, so that a daily rotation is
+	// recognized as due once a local midnight has passed since then.
+	start := time.Now()
+	for {
+		wait := r.nextRotation()
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-stop:
+			t.Stop()
+			return
+		case <-wake:
+			t.Stop()
+			continue
+		}
+
+		r.mu.Lock()
+		now := time.Now()
+		sy, sm, sd := start.Date()
+		ny, nm, nd := now.Date()
+		newDay := r.daily && (sy != ny || sm != nm || sd != nd)
+		due := r.size > 0 && (newDay || r.nextRotationLocked().Sub(now) <= 0)
+		start = now
+		if due {
+			err := r.rotate()
+			if err == nil {
+				r.size = 0
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// nextRotation returns the duration to wait before the next scheduled
+// rotation check.
+func (r *Rotator) nextRotation() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.nextRotationLocked().Sub(time.Now())
+}
+
+// nextRotationLocked computes the next scheduled rotation time.  r.mu must be
+// held by the caller.
+func (r *Rotator) nextRotationLocked() time.Time {
+	now := time.Now()
+	next := now.Add(24 * time.Hour)
+
+	if r.daily {
+		y, m, d := now.Date()
+		midnight := time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+		if midnight.Before(next) {
+			next = midnight
+		}
+	}
+	if r.maxAge > 0 && !r.firstWrite.IsZero() {
+		deadline := r.firstWrite.Add(r.maxAge)
+		if deadline.Before(next) {
+			next = deadline
+		}
+	}
+
+	return next
+}
+
 // Run begins reading lines from the reader and rotating logs as necessary.  Run
 // should not be called concurrently with Write.
 //
@@ -114,13 +358,16 @@ func (r *Rotator) SetCompressor(zw Compressor, suffix string) {
 func (r *Rotator) Run(reader io.Reader) error {
 	in := bufio.NewReader(reader)
 
+	r.mu.Lock()
 	// Rotate file immediately if it is already over the size limit.
 	if r.size >= r.threshold {
 		if err := r.rotate(); err != nil {
+			r.mu.Unlock()
 			return err
 		}
 		r.size = 0
 	}
+	r.mu.Unlock()
 
 	for {
 		line, isPrefix, err := in.ReadLine()
@@ -128,12 +375,19 @@ func (r *Rotator) Run(reader io.Reader) error {
 			return err
 		}
 
+		r.mu.Lock()
+		if r.firstWrite.IsZero() {
+			r.firstWrite = time.Now()
+			r.wakeTimer()
+		}
+
 		n, _ := r.out.Write(line)
 		r.size += int64(n)
 		if r.tee {
 			os.Stdout.Write(line)
 		}
 		if isPrefix {
+			r.mu.Unlock()
 			continue
 		}
 
@@ -146,16 +400,26 @@ func (r *Rotator) Run(reader io.Reader) error {
 		if r.size >= r.threshold {
 			err := r.rotate()
 			if err != nil {
+				r.mu.Unlock()
 				return err
 			}
 			r.size = 0
 		}
+		r.mu.Unlock()
 	}
 }
 
 // Write implements the io.Writer interface for Rotator.  If p ends in a newline
 // and the file has exceeded the threshold size, the file is rotated.
 func (r *Rotator) Write(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.firstWrite.IsZero() {
+		r.firstWrite = time.Now()
+		r.wakeTimer()
+	}
+
 	n, _ = r.out.Write(p)
 	r.size += int64(n)
 
@@ -170,60 +434,69 @@ func (r *Rotator) Write(p []byte) (n int, err error) {
 	return n, nil
 }
 
-// Close closes the output logfile.
+// Close closes the output logfile, stops the rotation schedule timer, if one
+// is running, and releases the cross-process rotation lock taken by New.
 func (r *Rotator) Close() error {
+	if r.timerStop != nil {
+		close(r.timerStop)
+		<-r.timerDone
+	}
+
+	r.mu.Lock()
 	err := r.out.Close()
+	r.mu.Unlock()
+
 	r.wg.Wait()
+
+	if e := r.lock.Close(); err == nil {
+		err = e
+	}
 	return err
 }
 
+// rotate closes the current log file, renames it as the next numbered
+// rotation, prunes old rotations past maxRolls, and opens a new file in its
+// place.  The caller must hold r.mu.
 func (r *Rotator) rotate() error {
 	dir := filepath.Dir(r.filename)
-	glob := filepath.Join(dir, filepath.Base(r.filename)+".*")
-	existing, err := filepath.Glob(glob)
+	existing, err := r.listRotated()
 	if err != nil {
 		return err
 	}
 
 	maxNum := 0
-	for _, name := range existing {
-		parts := strings.Split(name, ".")
-		if len(parts) < 2 {
-			continue
-		}
-		numIdx := len(parts) - 1
-		if parts[numIdx] == r.zSuffix {
-			numIdx--
-		}
-		num, err := strconv.Atoi(parts[numIdx])
-		if err != nil {
-			continue
-		}
-		if num > maxNum {
-			maxNum = num
+	for _, f := range existing {
+		if f.seq > maxNum {
+			maxNum = f.seq
 		}
 	}
 
+	// Fsync the current log before closing and renaming it so that its
+	// contents are durable even if the process crashes immediately after.
+	err = r.out.Sync()
+	if err != nil {
+		return err
+	}
 	err = r.out.Close()
 	if err != nil {
 		return err
 	}
-	rotname := fmt.Sprintf("%s.%d", r.filename, maxNum+1)
+	rotname := r.namer.RotatedName(r.filename, maxNum+1, time.Now())
 	err = os.Rename(r.filename, rotname)
 	if err != nil {
 		return err
 	}
+	if err := fsyncDir(dir); err != nil {
+		return err
+	}
 	if r.maxRolls > 0 {
-		for n := maxNum + 1 - r.maxRolls; n >= 1; n-- {
-			var name string
-			if r.zw == nil || r.zSuffix == "" {
-				name = fmt.Sprintf("%s.%d", r.filename, n)
-			} else {
-				name = fmt.Sprintf("%s.%d.%s", r.filename, n, r.zSuffix)
-			}
-			err := os.Remove(name)
-			if err != nil {
-				break
+		cutoff := maxNum + 1 - r.maxRolls
+		for _, f := range existing {
+			if f.seq <= cutoff {
+				os.Remove(f.path)
+				if f.stale != "" {
+					os.Remove(f.stale)
+				}
 			}
 		}
 	}
@@ -231,24 +504,158 @@ func (r *Rotator) rotate() error {
 	if err != nil {
 		return err
 	}
+	r.firstWrite = time.Time{}
 
-	if r.zw != nil {
+	if r.zw != nil || r.retentionAge > 0 {
 		r.wg.Add(1)
 		go func() {
-			r.zMu.Lock()
-			defer r.zMu.Unlock()
+			defer r.wg.Done()
 
-			err := r.compress(rotname)
-			if err == nil {
-				os.Remove(rotname)
+			if r.zw != nil {
+				r.zMu.Lock()
+				err := r.compress(rotname)
+				r.zMu.Unlock()
+				if err == nil {
+					os.Remove(rotname)
+				}
 			}
-			r.wg.Done()
+			r.pruneOld()
 		}()
 	}
 
 	return nil
 }
 
+// rotatedFile is an existing rotated log file, as recognized by the
+// Rotator's Namer.
+type rotatedFile struct {
+	path string
+	seq  int
+	t    time.Time
+
+	// stale is the path of a redundant duplicate of this rotation, if one
+	// exists (see rotationGroup), and is always safe to remove alongside
+	// path.
+	stale string
+}
+
+// rotationGroup is one rotation recognized by a Namer, deduped to prefer a
+// compressed copy over an uncompressed one when both exist for the same
+// seq.
+type rotationGroup struct {
+	seq   int
+	t     time.Time
+	path  string
+	gz    bool
+	stale string
+}
+
+// groupRotations parses existing file names with namer, stripping a
+// trailing "."+zSuffix first when present, and dedupes them to one group
+// per seq.  When both an uncompressed "name.N" and a compressed
+// "name.N.gz" exist for the same seq — as happens transiently while
+// rotate's background compression is renaming one into the other, or
+// permanently if a crash lands in that window before the uncompressed
+// original is removed — the compressed copy is preferred and the
+// uncompressed one is returned as stale rather than dropped outright, so
+// callers that prune by seq or age can still clean it up.
+func groupRotations(existing []string, zSuffix string, namer Namer) []rotationGroup {
+	bySeq := make(map[int]rotationGroup)
+	for _, name := range existing {
+		base := name
+		gz := false
+		if zSuffix != "" && strings.HasSuffix(base, "."+zSuffix) {
+			gz = true
+			base = strings.TrimSuffix(base, "."+zSuffix)
+		}
+		seq, t, ok := namer.Parse(base)
+		if !ok {
+			continue
+		}
+
+		cur, seen := bySeq[seq]
+		switch {
+		case !seen:
+			bySeq[seq] = rotationGroup{seq: seq, t: t, path: name, gz: gz}
+		case gz && !cur.gz:
+			cur.stale = cur.path
+			cur.path, cur.gz, cur.t = name, true, t
+			bySeq[seq] = cur
+		case !gz && cur.gz:
+			cur.stale = name
+			bySeq[seq] = cur
+		}
+	}
+
+	groups := make([]rotationGroup, 0, len(bySeq))
+	for _, g := range bySeq {
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+// listRotated globs for files belonging to r.filename and parses each one
+// with r.namer, discarding anything the Namer doesn't recognize as one of
+// its own rotations.  A file still awaiting compression is parsed the same
+// way as one already compressed, since a rotation may be renamed by the
+// Namer before the compression suffix is appended.
+func (r *Rotator) listRotated() ([]rotatedFile, error) {
+	dir := filepath.Dir(r.filename)
+	glob := filepath.Join(dir, filepath.Base(r.filename)+".*")
+	existing, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := groupRotations(existing, r.zSuffix, r.namer)
+	files := make([]rotatedFile, 0, len(groups))
+	for _, g := range groups {
+		files = append(files, rotatedFile{path: g.path, seq: g.seq, t: g.t, stale: g.stale})
+	}
+	return files, nil
+}
+
+// pruneOld removes rotated sibling files whose modification time is older
+// than the configured retention age.  It is safe to call even when no
+// retention age is configured, and is run in the background alongside
+// compression so that it never blocks writers.
+//
+// Only files r.namer recognizes as rotations of r.filename (via
+// listRotated) are candidates for removal; a raw glob over filename+".*"
+// would also match unrelated siblings such as the filename+".lock"
+// lockfile taken by New, which never has its mtime touched and would
+// otherwise get unlinked out from under a still-running, still-flocked
+// process.
+func (r *Rotator) pruneOld() {
+	if r.retentionAge <= 0 {
+		return
+	}
+
+	existing, err := r.listRotated()
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-r.retentionAge)
+	for _, f := range existing {
+		info, err := os.Stat(f.path)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Before(cutoff) {
+			os.Remove(f.path)
+			if f.stale != "" {
+				os.Remove(f.stale)
+			}
+		}
+	}
+}
+
+// compress gzips name to name+"."+r.zSuffix.  To avoid ever leaving a
+// truncated archive behind if the process crashes mid-write, the compressed
+// data is written to a ".tmp" file, fsynced, and only then renamed onto the
+// final name; the containing directory is fsynced afterward so the rename
+// itself is durable.
 func (r *Rotator) compress(name string) (err error) {
 	f, err := os.Open(name)
 	if err != nil {
@@ -257,23 +664,30 @@ func (r *Rotator) compress(name string) (err error) {
 	defer f.Close()
 
 	zname := fmt.Sprintf("%s.%s", name, r.zSuffix)
-	arc, err := os.OpenFile(zname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	tmpname := zname + ".tmp"
+	arc, err := os.OpenFile(tmpname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if e := arc.Close(); err == nil {
-			err = e
-		}
-	}()
 
 	r.zw.Reset(arc)
-	defer func() {
-		if e := r.zw.Close(); err == nil {
-			err = e
-		}
-	}()
-
 	_, err = io.Copy(r.zw, f)
-	return err
+	if e := r.zw.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = arc.Sync()
+	}
+	if e := arc.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+
+	if err = os.Rename(tmpname, zname); err != nil {
+		return err
+	}
+	return fsyncDir(filepath.Dir(zname))
 }
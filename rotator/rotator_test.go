@@ -0,0 +1,172 @@
+// Copyright (c) 2024 Josh Rickmar <jrick@zettaport.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// * Redistributions of source code must retain the above copyright notice, this
+//   list of conditions and the following disclaimer.
+//
+// * Redistributions in binary form must reproduce the above copyright notice,
+//   this list of conditions and the following disclaimer in the documentation
+//   and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package rotator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPruneOldSkipsLockfile guards against pruneOld reaping the
+// filename+".lock" file taken by New: even though the lockfile's mtime
+// never changes and can predate an age-based retention cutoff just like a
+// genuine rotation can, it must never be treated as a candidate for
+// removal.
+func TestPruneOldSkipsLockfile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.log")
+
+	r, err := New(filename, 1000, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.SetMaxAge(time.Nanosecond)
+
+	rotated := filename + ".1"
+	if err := os.WriteFile(rotated, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(rotated, past, past); err != nil {
+		t.Fatal(err)
+	}
+	lockPath := filename + ".lock"
+	if err := os.Chtimes(lockPath, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	r.pruneOld()
+
+	if _, err := os.Stat(rotated); !os.IsNotExist(err) {
+		t.Fatalf("expected rotated file to be pruned, stat err = %v", err)
+	}
+	if _, err := os.Stat(lockPath); err != nil {
+		t.Fatalf("lockfile was incorrectly pruned: %v", err)
+	}
+}
+
+// TestScheduleWakesOnFirstWrite guards against the first scheduled rotation
+// being delayed by up to 24h when SetRotationSchedule is configured before
+// any write has landed: the background timer must recompute its deadline
+// against firstWrite as soon as it is set, not merely on its next
+// incidental wakeup.
+func TestScheduleWakesOnFirstWrite(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.log")
+
+	r, err := New(filename, 1<<20, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	r.SetRotationSchedule(100 * time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, err := r.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(400 * time.Millisecond)
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-deadline:
+			t.Fatal("rotation did not occur within the expected window")
+		case <-tick.C:
+			if _, err := os.Stat(filename + ".1.gz"); err == nil {
+				return
+			}
+		}
+	}
+}
+
+// TestRotationScheduleComposesWithDaily guards against SetRotationSchedule
+// clobbering a daily rotation configured by an earlier SetDailyRotation
+// call: nextRotationLocked is written to honor both at once, so neither
+// setter should reset the other's state.
+func TestRotationScheduleComposesWithDaily(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.log")
+
+	r, err := New(filename, 1000, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	r.SetDailyRotation()
+	r.SetRotationSchedule(time.Hour)
+
+	if !r.daily {
+		t.Fatal("SetRotationSchedule must not disable daily rotation configured by SetDailyRotation")
+	}
+	if r.maxAge != time.Hour {
+		t.Fatalf("maxAge = %v, want %v", r.maxAge, time.Hour)
+	}
+}
+
+// TestPruneOldKeepsRecentRemovesOld is a basic regression test for
+// SetMaxAge's retention policy: rotated siblings older than the configured
+// age are removed, and ones still within it are left alone, independent of
+// maxRolls.
+func TestPruneOldKeepsRecentRemovesOld(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.log")
+
+	r, err := New(filename, 1000, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.SetMaxAge(time.Hour)
+
+	old := filename + ".1"
+	if err := os.WriteFile(old, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	recent := filename + ".2"
+	if err := os.WriteFile(recent, []byte("recent"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r.pruneOld()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("expected rotation older than maxAge to be pruned, stat err = %v", err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Fatalf("rotation within maxAge should not be pruned: %v", err)
+	}
+}